@@ -0,0 +1,168 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// outlierMADThreshold is how many median-absolute-deviations a quote may sit
+// from the other quotes' median before it's excluded from the aggregate as
+// an outlier.
+const outlierMADThreshold = 3.0
+
+// AggregatedPrice summarizes the quotes collected for one vs-currency after
+// outlier rejection.
+type AggregatedPrice struct {
+	VS      string  `json:"vs"`
+	Median  float64 `json:"median"`
+	Mean    float64 `json:"mean"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	VWAP    float64 `json:"vwap,omitempty"`
+	Sources int     `json:"sources"`
+}
+
+// SourceDeviation reports how far one source's quote sat from the median of
+// the other sources' quotes, and whether it was excluded from the aggregate
+// as an outlier.
+type SourceDeviation struct {
+	Source    string  `json:"source"`
+	Price     float64 `json:"price"`
+	Deviation float64 `json:"deviation"`
+	Outlier   bool    `json:"outlier"`
+}
+
+// computeAggregate rejects quotes more than outlierMADThreshold
+// median-absolute-deviations from a leave-one-out baseline (every other
+// quote's median and MAD), then returns the median/mean/min/max (and
+// volume-weighted average, when any quote reports volume) across what's
+// left, plus a deviation report covering every quote. Scoring each quote
+// against the others rather than against a baseline it contributed to
+// keeps one bad feed from inflating its own rejection threshold.
+func computeAggregate(vs string, quotes []SourceQuote) (AggregatedPrice, []SourceDeviation) {
+	prices := make([]float64, 0, len(quotes))
+	for _, q := range quotes {
+		if price, err := strconv.ParseFloat(q.Quote.Price, 64); err == nil {
+			prices = append(prices, price)
+		}
+	}
+
+	deviations := make([]SourceDeviation, 0, len(quotes))
+	kept := make([]float64, 0, len(quotes))
+	var weightedSum, totalVolume float64
+	next := 0
+	for _, q := range quotes {
+		price, err := strconv.ParseFloat(q.Quote.Price, 64)
+		if err != nil {
+			continue
+		}
+		baseline := leaveOneOut(prices, next)
+		next++
+		baseMedian := medianOf(baseline)
+		baseMAD := medianAbsoluteDeviation(baseline, baseMedian)
+
+		deviation := math.Abs(price - baseMedian)
+		outlier := baseMAD > 0 && deviation/baseMAD > outlierMADThreshold
+		deviations = append(deviations, SourceDeviation{
+			Source:    q.Source,
+			Price:     price,
+			Deviation: deviation,
+			Outlier:   outlier,
+		})
+		if outlier {
+			continue
+		}
+		kept = append(kept, price)
+		if q.Quote.Volume > 0 {
+			weightedSum += price * q.Quote.Volume
+			totalVolume += q.Quote.Volume
+		}
+	}
+
+	agg := AggregatedPrice{VS: vs, Sources: len(kept)}
+	if len(kept) > 0 {
+		agg.Median = medianOf(kept)
+		agg.Mean = meanOf(kept)
+		agg.Min = kept[0]
+		agg.Max = kept[0]
+		for _, p := range kept {
+			if p < agg.Min {
+				agg.Min = p
+			}
+			if p > agg.Max {
+				agg.Max = p
+			}
+		}
+	}
+	if totalVolume > 0 {
+		agg.VWAP = weightedSum / totalVolume
+	}
+	return agg, deviations
+}
+
+// parsedQuotes converts APIResponse-shaped string prices for one vs-currency
+// into SourceQuote, skipping sources that didn't have a price (including
+// the unsupportedQuote marker). A non-numeric price is passed through here
+// and skipped later by computeAggregate. Volume comes
+// along when the source reported one, so computeAggregate's VWAP isn't
+// always empty on this path.
+func parsedQuotes(responses []APIResponse, vs string) []SourceQuote {
+	quotes := make([]SourceQuote, 0, len(responses))
+	for _, r := range responses {
+		priceStr, ok := r.Price[vs]
+		if !ok || priceStr == unsupportedQuote {
+			continue
+		}
+		quotes = append(quotes, SourceQuote{Source: r.Source, Quote: Quote{VS: vs, Price: priceStr, Volume: r.Volume[vs]}})
+	}
+	return quotes
+}
+
+func medianOf(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func meanOf(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func medianAbsoluteDeviation(values []float64, median float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - median)
+	}
+	return medianOf(deviations)
+}
+
+// leaveOneOut returns a copy of values with the element at index excluded,
+// so its median/MAD can be used as a baseline that the excluded value
+// can't itself skew.
+func leaveOneOut(values []float64, index int) []float64 {
+	out := make([]float64, 0, len(values)-1)
+	for i, v := range values {
+		if i != index {
+			out = append(out, v)
+		}
+	}
+	return out
+}
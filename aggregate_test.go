@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestMedianOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   float64
+	}{
+		{"empty", nil, 0},
+		{"single", []float64{5}, 5},
+		{"odd length", []float64{3, 1, 2}, 2},
+		{"even length", []float64{1, 2, 3, 4}, 2.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := medianOf(tt.values); got != tt.want {
+				t.Errorf("medianOf(%v) = %v, want %v", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMeanOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   float64
+	}{
+		{"empty", nil, 0},
+		{"single", []float64{5}, 5},
+		{"several", []float64{1, 2, 3, 4}, 2.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := meanOf(tt.values); got != tt.want {
+				t.Errorf("meanOf(%v) = %v, want %v", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeAggregateOutlierRejection(t *testing.T) {
+	base := []float64{99, 100, 100, 101}
+	median := medianOf(base)
+	mad := medianAbsoluteDeviation(base, median)
+
+	atThreshold := median + mad*outlierMADThreshold
+	justOverThreshold := atThreshold + 0.01
+
+	tests := []struct {
+		name        string
+		outlier     float64
+		wantSources int
+	}{
+		{"exactly at threshold is kept", atThreshold, len(base) + 1},
+		{"just over threshold is rejected", justOverThreshold, len(base)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quotes := quotesFromPrices(append(append([]float64(nil), base...), tt.outlier))
+			agg, deviations := computeAggregate("usd", quotes)
+			if agg.Sources != tt.wantSources {
+				t.Errorf("Sources = %d, want %d", agg.Sources, tt.wantSources)
+			}
+			if len(deviations) != len(quotes) {
+				t.Errorf("deviations reported for %d quotes, want %d (every quote, outlier or not)", len(deviations), len(quotes))
+			}
+		})
+	}
+}
+
+func TestComputeAggregateMedianMeanMinMax(t *testing.T) {
+	quotes := quotesFromPrices([]float64{10, 20, 30})
+	agg, _ := computeAggregate("usd", quotes)
+
+	if agg.Median != 20 {
+		t.Errorf("Median = %v, want 20", agg.Median)
+	}
+	if agg.Mean != 20 {
+		t.Errorf("Mean = %v, want 20", agg.Mean)
+	}
+	if agg.Min != 10 {
+		t.Errorf("Min = %v, want 10", agg.Min)
+	}
+	if agg.Max != 30 {
+		t.Errorf("Max = %v, want 30", agg.Max)
+	}
+}
+
+func TestComputeAggregateVWAP(t *testing.T) {
+	quotes := []SourceQuote{
+		{Source: "A", Quote: Quote{VS: "usd", Price: "100", Volume: 1}},
+		{Source: "B", Quote: Quote{VS: "usd", Price: "200", Volume: 3}},
+	}
+	agg, _ := computeAggregate("usd", quotes)
+
+	want := (100*1.0 + 200*3.0) / (1.0 + 3.0) // volume-weighted average of 100 and 200
+	if math.Abs(agg.VWAP-want) > 1e-9 {
+		t.Errorf("VWAP = %v, want %v", agg.VWAP, want)
+	}
+}
+
+func TestComputeAggregateNoVolumeOmitsVWAP(t *testing.T) {
+	quotes := quotesFromPrices([]float64{10, 20, 30})
+	agg, _ := computeAggregate("usd", quotes)
+	if agg.VWAP != 0 {
+		t.Errorf("VWAP = %v, want 0 when no quote reports volume", agg.VWAP)
+	}
+}
+
+// quotesFromPrices builds SourceQuotes with no volume, one per price, so
+// tests can focus on the median/MAD/outlier math without reporting venues.
+func quotesFromPrices(prices []float64) []SourceQuote {
+	quotes := make([]SourceQuote, len(prices))
+	for i, p := range prices {
+		quotes[i] = SourceQuote{Source: fmt.Sprintf("source-%d", i), Quote: Quote{VS: "usd", Price: fmt.Sprintf("%v", p)}}
+	}
+	return quotes
+}
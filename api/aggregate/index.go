@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type APIResponse struct {
+	Source string             `json:"source"`
+	Price  map[string]string  `json:"price"`
+	Volume map[string]float64 `json:"volume,omitempty"`
+}
+
+// unsupportedQuote marks a vs-currency that a given source has no pair for,
+// so callers can tell "not offered" apart from a transient fetch error.
+const unsupportedQuote = "unsupported"
+
+// krakenPairs maps a base symbol to its known Kraken pair per vs-currency.
+// Kraken's legacy asset codes (XXBT, ZUSD, ...) aren't algorithmically
+// derivable from ISO tickers, so pairs are listed explicitly as they're added.
+var krakenPairs = map[string]map[string]string{
+	"BTC":  {"USD": "XXBTZUSD", "EUR": "XXBTZEUR", "GBP": "XXBTZGBP"},
+	"ETH":  {"USD": "XETHZUSD", "EUR": "XETHZEUR", "GBP": "XETHZGBP", "BTC": "XETHXXBT"},
+	"SOL":  {"USD": "SOLUSD", "EUR": "SOLEUR"},
+	"DOGE": {"USD": "XDGUSD", "EUR": "XDGEUR"},
+	"SHIB": {"USD": "SHIBUSD"},
+}
+
+// priceClient is shared across all registered providers so their rate
+// limits and circuit breakers apply process-wide, not per-request.
+var priceClient = NewPriceClient()
+
+// Handler serves GET /api/aggregate/:symbol?vs=usd, returning only the
+// cross-source aggregate and a per-source deviation report.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	symbol := strings.TrimPrefix(r.URL.Path, "/api/aggregate/")
+	if symbol == "" {
+		http.Error(w, "Missing symbol", http.StatusBadRequest)
+		return
+	}
+	// Refresh is a no-op unless the table has gone stale, so this is cheap
+	// on every request but still keeps a long-lived warm instance current.
+	if err := symbolResolver.Refresh(); err != nil {
+		log.Printf("symbol resolver: refresh failed, serving from cache: %v", err)
+	}
+	vs := strings.ToLower(r.URL.Query().Get("vs"))
+	if vs == "" {
+		vs = "usd"
+	}
+
+	quotes := aggregator.FetchQuotes(r.Context(), symbol, vs)
+	agg, deviations := computeAggregate(vs, quotes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"symbol":     strings.ToUpper(symbol),
+		"aggregate":  agg,
+		"deviations": deviations,
+	})
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// geckoCoinsListURL is CoinGecko's full coin directory, including each
+// coin's on-chain platform addresses so duplicate tickers can be told apart.
+const geckoCoinsListURL = "https://api.coingecko.com/api/v3/coins/list?include_platform=true"
+
+// GeckoToken is one entry of the CoinGecko coins/list response.
+type GeckoToken struct {
+	ID        string            `json:"id"`
+	Symbol    string            `json:"symbol"`
+	Name      string            `json:"name"`
+	Platforms map[string]string `json:"platforms"`
+}
+
+// SymbolResolver resolves a ticker symbol to a CoinGecko coin ID. Multiple
+// coins can share a ticker (e.g. USDC and its bridged variants), so each
+// symbol maps to all matching tokens and Lookup picks the best one.
+type SymbolResolver struct {
+	mu        sync.Mutex
+	tokens    map[string][]GeckoToken
+	fetchedAt time.Time
+	cachePath string
+	ttl       time.Duration
+}
+
+// NewSymbolResolver creates a resolver that persists its table to cachePath
+// and treats it as stale after ttl. The on-disk cache is loaded immediately
+// so a process that finds a cache file doesn't need a live fetch before it
+// can serve anything.
+func NewSymbolResolver(cachePath string, ttl time.Duration) *SymbolResolver {
+	r := &SymbolResolver{tokens: make(map[string][]GeckoToken), cachePath: cachePath, ttl: ttl}
+	if err := r.loadCache(); err != nil {
+		log.Printf("symbol resolver: no usable cache at %s: %v", cachePath, err)
+	}
+	return r
+}
+
+func (r *SymbolResolver) loadCache() error {
+	data, err := os.ReadFile(r.cachePath)
+	if err != nil {
+		return err
+	}
+
+	var cached struct {
+		Tokens    map[string][]GeckoToken `json:"tokens"`
+		FetchedAt time.Time               `json:"fetched_at"`
+	}
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.tokens = cached.Tokens
+	r.fetchedAt = cached.FetchedAt
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *SymbolResolver) saveCache() error {
+	r.mu.Lock()
+	snapshot := struct {
+		Tokens    map[string][]GeckoToken `json:"tokens"`
+		FetchedAt time.Time               `json:"fetched_at"`
+	}{Tokens: r.tokens, FetchedAt: r.fetchedAt}
+	r.mu.Unlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.cachePath, data, 0o644)
+}
+
+// Refresh re-fetches coins/list and rebuilds the symbol table, but only if
+// the current table is older than the resolver's ttl.
+func (r *SymbolResolver) Refresh() error {
+	r.mu.Lock()
+	stale := time.Since(r.fetchedAt) >= r.ttl
+	r.mu.Unlock()
+	if !stale {
+		return nil
+	}
+
+	resp, err := http.Get(geckoCoinsListURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching coins list, status code: %d", resp.StatusCode)
+	}
+
+	var list []GeckoToken
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return err
+	}
+
+	tokens := make(map[string][]GeckoToken)
+	for _, token := range list {
+		key := strings.ToUpper(token.Symbol)
+		tokens[key] = append(tokens[key], token)
+	}
+
+	r.mu.Lock()
+	r.tokens = tokens
+	r.fetchedAt = time.Now()
+	r.mu.Unlock()
+
+	return r.saveCache()
+}
+
+// Lookup resolves symbol to a CoinGecko coin ID. When a ticker is shared by
+// several coins, the one with an Ethereum platform address wins, falling
+// back to the first entry CoinGecko listed.
+func (r *SymbolResolver) Lookup(symbol string) (id string, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tokens := r.tokens[strings.ToUpper(symbol)]
+	if len(tokens) == 0 {
+		return "", false
+	}
+	for _, token := range tokens {
+		if addr, ok := token.Platforms["ethereum"]; ok && addr != "" {
+			return token.ID, true
+		}
+	}
+	return tokens[0].ID, true
+}
+
+// symbolResolver maps tickers to CoinGecko coin IDs, refreshing from
+// coins/list every 6h so newly listed coins work without a code change.
+// /tmp is the only writable path on Vercel's Go runtime, so the cache lives
+// there and survives across invocations on a warm instance.
+var symbolResolver = NewSymbolResolver("/tmp/coingecko_coins_cache.json", 6*time.Hour)
+
+func init() {
+	if err := symbolResolver.Refresh(); err != nil {
+		log.Printf("symbol resolver: initial refresh failed, serving from cache: %v", err)
+	}
+}
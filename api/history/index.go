@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PricePoint is a single (timestamp, price) sample of a market_chart series.
+type PricePoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Price     float64 `json:"price"`
+}
+
+// ErrCoinNotFound means the upstream source has no coin matching the symbol.
+var ErrCoinNotFound = errors.New("coin not found")
+
+// ErrTooManyRequests means the upstream source rate-limited this request.
+var ErrTooManyRequests = errors.New("too many requests")
+
+// priceClient enforces CoinGecko's rate limit and circuit breaker for this
+// function's market_chart calls. It is this function's own instance,
+// configured like the price-fetching entrypoints' clients; its budget is
+// not shared with them.
+var priceClient = NewPriceClient()
+
+// getHistoryFromCoinGecko fetches a market_chart series for coinID over the
+// given window, decoding CoinGecko's {"prices": [[ts_ms, price], ...]} shape.
+func getHistoryFromCoinGecko(coinID string, days int, interval, vs string) ([]PricePoint, error) {
+	url := fmt.Sprintf(
+		"https://api.coingecko.com/api/v3/coins/%s/market_chart?vs_currency=%s&days=%d&interval=%s",
+		coinID, strings.ToLower(vs), days, interval,
+	)
+
+	var result struct {
+		Prices [][2]float64 `json:"prices"`
+	}
+	if err := priceClient.fetchPrice("coingecko", url, &result); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.Code {
+			case http.StatusNotFound:
+				return nil, ErrCoinNotFound
+			case http.StatusTooManyRequests:
+				return nil, ErrTooManyRequests
+			}
+		}
+		return nil, err
+	}
+
+	points := make([]PricePoint, len(result.Prices))
+	for i, p := range result.Prices {
+		points[i] = PricePoint{Timestamp: int64(p[0]), Price: p[1]}
+	}
+	return points, nil
+}
+
+// mergeSeries buckets multiple price series by their (already shared)
+// timestamp so the response reports one row per sample instant.
+func mergeSeries(series map[string][]PricePoint) []map[string]interface{} {
+	buckets := make(map[int64]map[string]interface{})
+	var order []int64
+	for source, points := range series {
+		for _, p := range points {
+			row, ok := buckets[p.Timestamp]
+			if !ok {
+				row = map[string]interface{}{"timestamp": p.Timestamp}
+				buckets[p.Timestamp] = row
+				order = append(order, p.Timestamp)
+			}
+			row[source] = p.Price
+		}
+	}
+
+	rows := make([]map[string]interface{}, 0, len(order))
+	for _, ts := range order {
+		rows = append(rows, buckets[ts])
+	}
+	return rows
+}
+
+// Handler serves GET /api/history/:symbol?days=30&interval=daily&vs=usd
+func Handler(w http.ResponseWriter, r *http.Request) {
+	symbol := strings.TrimPrefix(r.URL.Path, "/api/history/")
+	if symbol == "" {
+		http.Error(w, "Missing symbol", http.StatusBadRequest)
+		return
+	}
+
+	// Refresh is a no-op unless the table has gone stale, so this is cheap
+	// on every request but still keeps a long-lived warm instance current.
+	if err := symbolResolver.Refresh(); err != nil {
+		log.Printf("symbol resolver: refresh failed, serving from cache: %v", err)
+	}
+
+	coinID, ok := symbolResolver.Lookup(symbol)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown symbol: %s", symbol), http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	days, err := strconv.Atoi(query.Get("days"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	interval := query.Get("interval")
+	if interval == "" {
+		interval = "daily"
+	}
+	vs := query.Get("vs")
+	if vs == "" {
+		vs = "usd"
+	}
+
+	points, err := getHistoryFromCoinGecko(coinID, days, interval, vs)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrCoinNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ErrTooManyRequests):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+
+	series := mergeSeries(map[string][]PricePoint{"CoinGecko": points})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"symbol": strings.ToUpper(symbol),
+		"vs":     strings.ToLower(vs),
+		"prices": series,
+	})
+}
@@ -2,152 +2,59 @@ package handler
 
 import (
 	"encoding/json"
-	"fmt"
+	"log"
 	"net/http"
 	"strings"
-	"sync"
 
 	"github.com/gin-gonic/gin"
 )
 
 type APIResponse struct {
-	Source string `json:"source"`
-	Price  string `json:"price"`
+	Source string             `json:"source"`
+	Price  map[string]string  `json:"price"`
+	Volume map[string]float64 `json:"volume,omitempty"`
 }
 
-var coinGeckoSymbols = map[string]string{
-	"BTC":  "bitcoin",
-	"ETH":  "ethereum",
-	"SOL":  "solana",
-	"DOGE": "dogecoin",
-	"SHIB": "shiba-inu",
+// unsupportedQuote marks a vs-currency that a given source has no pair for,
+// so callers can tell "not offered" apart from a transient fetch error.
+const unsupportedQuote = "unsupported"
+
+// krakenPairs maps a base symbol to its known Kraken pair per vs-currency.
+// Kraken's legacy asset codes (XXBT, ZUSD, ...) aren't algorithmically
+// derivable from ISO tickers, so pairs are listed explicitly as they're added.
+var krakenPairs = map[string]map[string]string{
+	"BTC":  {"USD": "XXBTZUSD", "EUR": "XXBTZEUR", "GBP": "XXBTZGBP"},
+	"ETH":  {"USD": "XETHZUSD", "EUR": "XETHZEUR", "GBP": "XETHZGBP", "BTC": "XETHXXBT"},
+	"SOL":  {"USD": "SOLUSD", "EUR": "SOLEUR"},
+	"DOGE": {"USD": "XDGUSD", "EUR": "XDGEUR"},
+	"SHIB": {"USD": "SHIBUSD"},
 }
 
-var krakenSymbols = map[string]string{
-	"BTC":  "XXBTZUSD",
-	"ETH":  "XETHZUSD",
-	"SOL":  "SOLUSD",
-	"DOGE": "XDGUSD",
-	"SHIB": "SHIBUSD",
-}
-
-func fetchPrice(url string, target interface{}) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error fetching price, status code: %d", resp.StatusCode)
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func getPriceFromBinance(symbol string) (string, error) {
-	url := fmt.Sprintf("https://api.binance.com/api/v3/ticker/price?symbol=%sUSDT", strings.ToUpper(symbol))
-	var result struct {
-		Price string `json:"price"`
-	}
-	err := fetchPrice(url, &result)
-	if err != nil {
-		return "", err
-	}
-	return result.Price, nil
-}
-
-func getPriceFromCoinGecko(symbol string) (string, error) {
-	coinGeckoSymbol, ok := coinGeckoSymbols[strings.ToUpper(symbol)]
-	if !ok {
-		return "", fmt.Errorf("unknown symbol for CoinGecko: %s", symbol)
-	}
-	url := fmt.Sprintf("https://api.coingecko.com/api/v3/simple/price?ids=%s&vs_currencies=usd", coinGeckoSymbol)
-	var result map[string]map[string]float64
-	err := fetchPrice(url, &result)
-	if err != nil {
-		return "", err
-	}
-	price := result[coinGeckoSymbol]["usd"]
-	return fmt.Sprintf("%.2f", price), nil
-}
-
-func getPriceFromKraken(symbol string) (string, error) {
-	krakenPair, ok := krakenSymbols[strings.ToUpper(symbol)]
-	if !ok {
-		return "", fmt.Errorf("unknown symbol for Kraken: %s", symbol)
-	}
+// defaultVSCurrencies is used when the caller doesn't specify ?vs=.
+var defaultVSCurrencies = []string{"usd"}
 
-	url := fmt.Sprintf("https://api.kraken.com/0/public/Ticker?pair=%s", krakenPair)
-	var result struct {
-		Result map[string]struct {
-			C []string `json:"c"`
-		} `json:"result"`
-	}
-	err := fetchPrice(url, &result)
-	if err != nil {
-		return "", err
-	}
-	priceList := result.Result[krakenPair]
-	if len(priceList.C) == 0 {
-		return "", fmt.Errorf("price not found for %s", symbol)
-	}
-	return priceList.C[0], nil
-}
+// priceClient is shared across all registered providers so their rate
+// limits and circuit breakers apply process-wide, not per-request.
+var priceClient = NewPriceClient()
 
-func getPriceFromCoinbase(symbol string) (string, error) {
-	url := fmt.Sprintf("https://api.coinbase.com/v2/prices/%s-USD/spot", strings.ToUpper(symbol))
-	var result struct {
-		Data struct {
-			Amount string `json:"amount"`
-		} `json:"data"`
-	}
-	err := fetchPrice(url, &result)
-	if err != nil {
-		return "", err
+// parseVSCurrencies turns a comma-separated "usd,eur,btc" query value into a
+// normalized, lower-cased list, defaulting to USD when empty.
+func parseVSCurrencies(vs string) []string {
+	if strings.TrimSpace(vs) == "" {
+		return defaultVSCurrencies
 	}
-	return result.Data.Amount, nil
-}
-
-func fetchPricesConcurrently(symbol string) []APIResponse {
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	sources := []struct {
-		Name   string
-		Fetch  func(string) (string, error)
-		Symbol string
-	}{
-		{"Binance", getPriceFromBinance, symbol},
-		{"CoinGecko", getPriceFromCoinGecko, symbol},
-		{"Kraken", getPriceFromKraken, symbol},
-		{"Coinbase", getPriceFromCoinbase, symbol},
+	parts := strings.Split(vs, ",")
+	currencies := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p != "" {
+			currencies = append(currencies, p)
+		}
 	}
-
-	prices := make([]APIResponse, len(sources))
-	for i, source := range sources {
-		wg.Add(1)
-		go func(i int, source struct {
-			Name   string
-			Fetch  func(string) (string, error)
-			Symbol string
-		}) {
-			defer wg.Done()
-			price, err := source.Fetch(source.Symbol)
-			if err != nil {
-				price = "Error fetching price"
-			}
-			mu.Lock()
-			prices[i] = APIResponse{Source: fmt.Sprintf("%s (%s)", source.Name, strings.ToUpper(symbol)), Price: price}
-			mu.Unlock()
-		}(i, source)
+	if len(currencies) == 0 {
+		return defaultVSCurrencies
 	}
-
-	wg.Wait()
-	return prices
+	return currencies
 }
 
 // Handler is the main function that will handle requests
@@ -157,7 +64,20 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing symbol", http.StatusBadRequest)
 		return
 	}
-	prices := fetchPricesConcurrently(symbol)
+	// Refresh is a no-op unless the table has gone stale, so this is cheap
+	// on every request but still keeps a long-lived warm instance current.
+	if err := symbolResolver.Refresh(); err != nil {
+		log.Printf("symbol resolver: refresh failed, serving from cache: %v", err)
+	}
+	vsCurrencies := parseVSCurrencies(r.URL.Query().Get("vs"))
+	prices := aggregator.Fetch(r.Context(), []string{symbol}, vsCurrencies)
+
+	aggregated := make(map[string]AggregatedPrice, len(vsCurrencies))
+	for _, vs := range vsCurrencies {
+		agg, _ := computeAggregate(vs, parsedQuotes(prices, vs))
+		aggregated[vs] = agg
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(gin.H{"prices": prices})
+	json.NewEncoder(w).Encode(gin.H{"prices": prices, "aggregated": aggregated})
 }
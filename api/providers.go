@@ -0,0 +1,564 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Quote is a single provider's answer for one (symbol, vs) pair. Volume is
+// the provider's reported 24h base-asset volume, 0 when it doesn't report
+// one; aggregation only includes volume-weighting from providers that do.
+type Quote struct {
+	VS     string
+	Price  string
+	Volume float64
+}
+
+// Provider is one price source. Implementations own their own symbol
+// mapping and rate limiting; Aggregator only needs to know what a provider
+// is named, whether it can answer a given pair, and how to fetch it.
+type Provider interface {
+	Name() string
+	Supports(symbol, vs string) bool
+	FetchPrice(ctx context.Context, symbol, vs string) (Quote, error)
+}
+
+// BatchProvider is implemented by sources whose upstream API accepts a
+// comma-separated list of vs-currencies in one call (CoinGecko's
+// vs_currencies, Kraken's pair param). Aggregator prefers this over calling
+// FetchPrice once per vs-currency so a multi-currency request still costs
+// one round trip, preserving the batching chunk0-1 built and the request
+// budget chunk0-4 enforces. Quotes are keyed by lowercased vs-currency; a
+// vs-currency missing from the returned map means that pair wasn't present
+// in the response, distinct from a transport/decode error which is
+// returned directly.
+type BatchProvider interface {
+	Provider
+	FetchPrices(ctx context.Context, symbol string, vsCurrencies []string) (map[string]Quote, error)
+}
+
+// MultiSymbolProvider is implemented by sources whose upstream API accepts
+// several base symbols alongside several vs-currencies in one call
+// (CryptoCompare's pricemulti: fsyms=BTC,ETH&tsyms=USD,EUR). Aggregator
+// fetches every symbol it was asked about through such a provider in a
+// single round trip instead of one per symbol, which is the whole reason
+// a source like this gets added over a single-pair one.
+type MultiSymbolProvider interface {
+	Provider
+	FetchPricesMulti(ctx context.Context, symbols []string, vsCurrencies []string) (map[string]map[string]Quote, error)
+}
+
+type binanceProvider struct{ client *PriceClient }
+
+func (p *binanceProvider) Name() string { return "Binance" }
+
+// binanceVSCurrency maps a vs-currency to the symbol Binance's spot market
+// actually lists it under. Binance has no raw USD pairs, only
+// stablecoin-quoted ones, so the default ?vs=usd path needs to resolve to
+// USDT or every unparameterized request would quietly come back
+// unsupported.
+func binanceVSCurrency(vs string) string {
+	if strings.EqualFold(vs, "usd") {
+		return "usdt"
+	}
+	return vs
+}
+
+func (p *binanceProvider) Supports(symbol, vs string) bool { return true }
+
+func (p *binanceProvider) FetchPrice(ctx context.Context, symbol, vs string) (Quote, error) {
+	pair := fmt.Sprintf("%s%s", strings.ToUpper(symbol), strings.ToUpper(binanceVSCurrency(vs)))
+	url := fmt.Sprintf("https://api.binance.com/api/v3/ticker/24hr?symbol=%s", pair)
+	var result struct {
+		LastPrice string `json:"lastPrice"`
+		Volume    string `json:"volume"`
+	}
+	if err := p.client.fetchPrice("binance", url, &result); err != nil {
+		return Quote{}, err
+	}
+	volume, _ := strconv.ParseFloat(result.Volume, 64)
+	return Quote{VS: strings.ToLower(vs), Price: result.LastPrice, Volume: volume}, nil
+}
+
+type coinGeckoProvider struct {
+	client   *PriceClient
+	resolver *SymbolResolver
+}
+
+func (p *coinGeckoProvider) Name() string { return "CoinGecko" }
+
+func (p *coinGeckoProvider) Supports(symbol, vs string) bool {
+	_, ok := p.resolver.Lookup(symbol)
+	return ok
+}
+
+func (p *coinGeckoProvider) FetchPrice(ctx context.Context, symbol, vs string) (Quote, error) {
+	quotes, err := p.FetchPrices(ctx, symbol, []string{vs})
+	if err != nil {
+		return Quote{}, err
+	}
+	quote, ok := quotes[strings.ToLower(vs)]
+	if !ok {
+		return Quote{}, fmt.Errorf("no %s price for %s", vs, symbol)
+	}
+	return quote, nil
+}
+
+// FetchPrices fetches every requested vs-currency in one simple/price call
+// via a comma-joined vs_currencies list, so a multi-currency request costs
+// one round trip against CoinGecko's tight free-tier rate limit, not one
+// per vs-currency.
+func (p *coinGeckoProvider) FetchPrices(ctx context.Context, symbol string, vsCurrencies []string) (map[string]Quote, error) {
+	coinID, ok := p.resolver.Lookup(symbol)
+	if !ok {
+		return nil, fmt.Errorf("unknown symbol for CoinGecko: %s", symbol)
+	}
+	lowered := make([]string, len(vsCurrencies))
+	for i, vs := range vsCurrencies {
+		lowered[i] = strings.ToLower(vs)
+	}
+	url := fmt.Sprintf("https://api.coingecko.com/api/v3/simple/price?ids=%s&vs_currencies=%s", coinID, strings.Join(lowered, ","))
+	var result map[string]map[string]float64
+	if err := p.client.fetchPrice("coingecko", url, &result); err != nil {
+		return nil, err
+	}
+	quotes := make(map[string]Quote, len(lowered))
+	for _, vs := range lowered {
+		price, ok := result[coinID][vs]
+		if !ok {
+			continue
+		}
+		quotes[vs] = Quote{VS: vs, Price: fmt.Sprintf("%.2f", price)}
+	}
+	return quotes, nil
+}
+
+type krakenProvider struct{ client *PriceClient }
+
+func (p *krakenProvider) Name() string { return "Kraken" }
+
+func (p *krakenProvider) Supports(symbol, vs string) bool {
+	_, ok := krakenPairs[strings.ToUpper(symbol)][strings.ToUpper(vs)]
+	return ok
+}
+
+func (p *krakenProvider) FetchPrice(ctx context.Context, symbol, vs string) (Quote, error) {
+	quotes, err := p.FetchPrices(ctx, symbol, []string{vs})
+	if err != nil {
+		return Quote{}, err
+	}
+	quote, ok := quotes[strings.ToLower(vs)]
+	if !ok {
+		return Quote{}, fmt.Errorf("price not found for %s/%s", symbol, vs)
+	}
+	return quote, nil
+}
+
+// FetchPrices fetches every requested vs-currency in one Ticker call via a
+// comma-joined pair list, instead of one request per vs-currency.
+func (p *krakenProvider) FetchPrices(ctx context.Context, symbol string, vsCurrencies []string) (map[string]Quote, error) {
+	pairToVS := make(map[string]string, len(vsCurrencies))
+	pairs := make([]string, 0, len(vsCurrencies))
+	for _, vs := range vsCurrencies {
+		pair, ok := krakenPairs[strings.ToUpper(symbol)][strings.ToUpper(vs)]
+		if !ok {
+			continue
+		}
+		pairToVS[pair] = strings.ToLower(vs)
+		pairs = append(pairs, pair)
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("unknown pair for Kraken: %s", symbol)
+	}
+	url := fmt.Sprintf("https://api.kraken.com/0/public/Ticker?pair=%s", strings.Join(pairs, ","))
+	var result struct {
+		Result map[string]struct {
+			C []string `json:"c"` // last trade closed [price, lot volume]
+			V []string `json:"v"` // volume [today, last 24h]
+		} `json:"result"`
+	}
+	if err := p.client.fetchPrice("kraken", url, &result); err != nil {
+		return nil, err
+	}
+	quotes := make(map[string]Quote, len(pairs))
+	for pair, vs := range pairToVS {
+		ticker, ok := result.Result[pair]
+		if !ok || len(ticker.C) == 0 {
+			continue
+		}
+		var volume float64
+		if len(ticker.V) == 2 {
+			volume, _ = strconv.ParseFloat(ticker.V[1], 64)
+		}
+		quotes[vs] = Quote{VS: vs, Price: ticker.C[0], Volume: volume}
+	}
+	return quotes, nil
+}
+
+type coinbaseProvider struct{ client *PriceClient }
+
+func (p *coinbaseProvider) Name() string { return "Coinbase" }
+
+func (p *coinbaseProvider) Supports(symbol, vs string) bool { return true }
+
+func (p *coinbaseProvider) FetchPrice(ctx context.Context, symbol, vs string) (Quote, error) {
+	url := fmt.Sprintf("https://api.coinbase.com/v2/prices/%s-%s/spot", strings.ToUpper(symbol), strings.ToUpper(vs))
+	var result struct {
+		Data struct {
+			Amount string `json:"amount"`
+		} `json:"data"`
+	}
+	if err := p.client.fetchPrice("coinbase", url, &result); err != nil {
+		return Quote{}, err
+	}
+	return Quote{VS: strings.ToLower(vs), Price: result.Data.Amount}, nil
+}
+
+// cryptoCompareProvider hits pricemulti, which accepts a comma-separated
+// fsyms list alongside tsyms, so it implements MultiSymbolProvider to batch
+// every symbol the aggregator is asked about (not just the vs-currencies)
+// into one round trip.
+type cryptoCompareProvider struct{ client *PriceClient }
+
+func (p *cryptoCompareProvider) Name() string { return "CryptoCompare" }
+
+func (p *cryptoCompareProvider) Supports(symbol, vs string) bool { return true }
+
+func (p *cryptoCompareProvider) FetchPrice(ctx context.Context, symbol, vs string) (Quote, error) {
+	quotes, err := p.FetchPricesMulti(ctx, []string{symbol}, []string{vs})
+	if err != nil {
+		return Quote{}, err
+	}
+	quote, ok := quotes[strings.ToLower(symbol)][strings.ToLower(vs)]
+	if !ok {
+		return Quote{}, fmt.Errorf("no %s price for %s", vs, symbol)
+	}
+	return quote, nil
+}
+
+// FetchPricesMulti fetches every symbol/vs-currency combination in one
+// pricemulti call, so asking for many symbols (and many vs-currencies)
+// costs one round trip instead of one per symbol.
+func (p *cryptoCompareProvider) FetchPricesMulti(ctx context.Context, symbols []string, vsCurrencies []string) (map[string]map[string]Quote, error) {
+	fsyms := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		fsyms[i] = strings.ToUpper(symbol)
+	}
+	tsyms := make([]string, len(vsCurrencies))
+	for i, vs := range vsCurrencies {
+		tsyms[i] = strings.ToUpper(vs)
+	}
+	url := fmt.Sprintf("https://min-api.cryptocompare.com/data/pricemulti?fsyms=%s&tsyms=%s", strings.Join(fsyms, ","), strings.Join(tsyms, ","))
+	var result map[string]map[string]float64
+	if err := p.client.fetchPrice("cryptocompare", url, &result); err != nil {
+		return nil, err
+	}
+
+	quotes := make(map[string]map[string]Quote, len(result))
+	for symbol, byVS := range result {
+		vsQuotes := make(map[string]Quote, len(byVS))
+		for vs, price := range byVS {
+			vs = strings.ToLower(vs)
+			vsQuotes[vs] = Quote{VS: vs, Price: fmt.Sprintf("%.2f", price)}
+		}
+		quotes[strings.ToLower(symbol)] = vsQuotes
+	}
+	return quotes, nil
+}
+
+type bitfinexProvider struct{ client *PriceClient }
+
+func (p *bitfinexProvider) Name() string { return "Bitfinex" }
+
+func (p *bitfinexProvider) Supports(symbol, vs string) bool { return true }
+
+func (p *bitfinexProvider) FetchPrice(ctx context.Context, symbol, vs string) (Quote, error) {
+	pair := fmt.Sprintf("t%s%s", strings.ToUpper(symbol), strings.ToUpper(vs))
+	url := fmt.Sprintf("https://api-pub.bitfinex.com/v2/ticker/%s", pair)
+	var result []float64
+	if err := p.client.fetchPrice("bitfinex", url, &result); err != nil {
+		return Quote{}, err
+	}
+	// [BID, BID_SIZE, ASK, ASK_SIZE, DAILY_CHANGE, DAILY_CHANGE_RELATIVE, LAST_PRICE, VOLUME, ...]
+	if len(result) < 8 {
+		return Quote{}, fmt.Errorf("unexpected ticker shape for %s", pair)
+	}
+	return Quote{VS: strings.ToLower(vs), Price: fmt.Sprintf("%.2f", result[6]), Volume: result[7]}, nil
+}
+
+// coinbaseAdvancedProvider hits Coinbase's Exchange/Advanced Trade public
+// ticker, which is kept distinct from coinbaseProvider's v2 spot price
+// because the two sit behind different rate limits and uptime.
+type coinbaseAdvancedProvider struct{ client *PriceClient }
+
+func (p *coinbaseAdvancedProvider) Name() string { return "CoinbaseAdvanced" }
+
+func (p *coinbaseAdvancedProvider) Supports(symbol, vs string) bool { return true }
+
+func (p *coinbaseAdvancedProvider) FetchPrice(ctx context.Context, symbol, vs string) (Quote, error) {
+	product := fmt.Sprintf("%s-%s", strings.ToUpper(symbol), strings.ToUpper(vs))
+	url := fmt.Sprintf("https://api.exchange.coinbase.com/products/%s/ticker", product)
+	var result struct {
+		Price string `json:"price"`
+	}
+	if err := p.client.fetchPrice("coinbaseadvanced", url, &result); err != nil {
+		return Quote{}, err
+	}
+	return Quote{VS: strings.ToLower(vs), Price: result.Price}, nil
+}
+
+// Aggregator fans a (symbols x vsCurrencies) request out across every
+// enabled Provider concurrently. This is the real extensibility point the
+// four hand-written getPriceFromX functions used to stand in for: adding a
+// source is now "implement Provider and register it", not editing a switch.
+type Aggregator struct {
+	providers []Provider
+	enabled   map[string]bool
+}
+
+// NewAggregator registers providers, all enabled by default.
+func NewAggregator(providers ...Provider) *Aggregator {
+	enabled := make(map[string]bool, len(providers))
+	for _, p := range providers {
+		enabled[p.Name()] = true
+	}
+	return &Aggregator{providers: providers, enabled: enabled}
+}
+
+// SetEnabled toggles a provider by name; unknown names are a no-op.
+func (a *Aggregator) SetEnabled(name string, on bool) {
+	a.enabled[name] = on
+}
+
+// aggregatorJob is one (symbol, provider) pair the aggregator owes a
+// result for.
+type aggregatorJob struct {
+	symbol   string
+	provider Provider
+}
+
+// Fetch queries every enabled provider for every symbol/vs combination
+// concurrently, returning one APIResponse per (provider, symbol).
+func (a *Aggregator) Fetch(ctx context.Context, symbols []string, vsCurrencies []string) []APIResponse {
+	var jobs []aggregatorJob
+	for _, symbol := range symbols {
+		for _, provider := range a.providers {
+			if a.enabled[provider.Name()] {
+				jobs = append(jobs, aggregatorJob{symbol, provider})
+			}
+		}
+	}
+
+	// Group by provider so a MultiSymbolProvider gets every symbol it was
+	// asked about in one round trip instead of one per symbol.
+	byProvider := make(map[Provider][]int, len(a.providers))
+	for i, j := range jobs {
+		byProvider[j.provider] = append(byProvider[j.provider], i)
+	}
+
+	results := make([]APIResponse, len(jobs))
+	var wg sync.WaitGroup
+	for provider, indices := range byProvider {
+		if multi, ok := provider.(MultiSymbolProvider); ok {
+			wg.Add(1)
+			go func(multi MultiSymbolProvider, indices []int) {
+				defer wg.Done()
+				a.fetchMultiSymbol(ctx, multi, jobs, indices, vsCurrencies, results)
+			}(multi, indices)
+			continue
+		}
+		for _, i := range indices {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = a.fetchOne(ctx, jobs[i].provider, jobs[i].symbol, vsCurrencies)
+			}(i)
+		}
+	}
+	wg.Wait()
+
+	return results
+}
+
+// fetchOne resolves a single (provider, symbol) job across every requested
+// vs-currency, preferring the provider's BatchProvider path when it has one.
+func (a *Aggregator) fetchOne(ctx context.Context, provider Provider, symbol string, vsCurrencies []string) APIResponse {
+	priceMap := make(map[string]string, len(vsCurrencies))
+	volumeMap := make(map[string]float64, len(vsCurrencies))
+	supported := make([]string, 0, len(vsCurrencies))
+	for _, vs := range vsCurrencies {
+		if provider.Supports(symbol, vs) {
+			supported = append(supported, vs)
+		} else {
+			priceMap[vs] = unsupportedQuote
+		}
+	}
+
+	if batch, ok := provider.(BatchProvider); ok && len(supported) > 0 {
+		quotes, err := batch.FetchPrices(ctx, symbol, supported)
+		if err != nil {
+			log.Printf("aggregator: %s batch fetch %s/%v failed: %v", provider.Name(), symbol, supported, err)
+		}
+		for _, vs := range supported {
+			quote, ok := quotes[strings.ToLower(vs)]
+			if !ok {
+				priceMap[vs] = unsupportedQuote
+				continue
+			}
+			priceMap[vs] = quote.Price
+			volumeMap[vs] = quote.Volume
+		}
+	} else {
+		for _, vs := range supported {
+			quote, err := provider.FetchPrice(ctx, symbol, vs)
+			if err != nil {
+				// Log the real cause (rate limit, open breaker, decoded
+				// APIError, ...) before collapsing it to the same
+				// sentinel a genuinely unsupported pair would get, so
+				// operators can tell those apart.
+				log.Printf("aggregator: %s %s/%s failed: %v", provider.Name(), symbol, vs, err)
+				priceMap[vs] = unsupportedQuote
+				continue
+			}
+			priceMap[vs] = quote.Price
+			volumeMap[vs] = quote.Volume
+		}
+	}
+	return APIResponse{
+		Source: fmt.Sprintf("%s (%s)", provider.Name(), strings.ToUpper(symbol)),
+		Price:  priceMap,
+		Volume: volumeMap,
+	}
+}
+
+// fetchMultiSymbol resolves every job assigned to a MultiSymbolProvider in
+// a single upstream call, batching both the symbols and the vs-currencies
+// those jobs need.
+func (a *Aggregator) fetchMultiSymbol(ctx context.Context, provider MultiSymbolProvider, jobs []aggregatorJob, indices []int, vsCurrencies []string, results []APIResponse) {
+	symbols := make([]string, 0, len(indices))
+	supportedBySymbol := make(map[string]map[string]bool, len(indices))
+	vsUnion := make(map[string]bool, len(vsCurrencies))
+	for _, i := range indices {
+		symbol := jobs[i].symbol
+		symbols = append(symbols, symbol)
+		supported := make(map[string]bool, len(vsCurrencies))
+		for _, vs := range vsCurrencies {
+			if provider.Supports(symbol, vs) {
+				supported[vs] = true
+				vsUnion[vs] = true
+			}
+		}
+		supportedBySymbol[symbol] = supported
+	}
+
+	union := make([]string, 0, len(vsUnion))
+	for vs := range vsUnion {
+		union = append(union, vs)
+	}
+
+	var quotes map[string]map[string]Quote
+	if len(union) > 0 {
+		var err error
+		quotes, err = provider.FetchPricesMulti(ctx, symbols, union)
+		if err != nil {
+			log.Printf("aggregator: %s multi-symbol fetch %v/%v failed: %v", provider.Name(), symbols, union, err)
+		}
+	}
+
+	for _, i := range indices {
+		symbol := jobs[i].symbol
+		priceMap := make(map[string]string, len(vsCurrencies))
+		volumeMap := make(map[string]float64, len(vsCurrencies))
+		for _, vs := range vsCurrencies {
+			if !supportedBySymbol[symbol][vs] {
+				priceMap[vs] = unsupportedQuote
+				continue
+			}
+			quote, ok := quotes[strings.ToLower(symbol)][strings.ToLower(vs)]
+			if !ok {
+				priceMap[vs] = unsupportedQuote
+				continue
+			}
+			priceMap[vs] = quote.Price
+			volumeMap[vs] = quote.Volume
+		}
+		results[i] = APIResponse{
+			Source: fmt.Sprintf("%s (%s)", provider.Name(), strings.ToUpper(symbol)),
+			Price:  priceMap,
+			Volume: volumeMap,
+		}
+	}
+}
+
+// SourceQuote pairs a provider's name with the Quote it returned, so a
+// caller aggregating across sources can still report where each one came
+// from.
+type SourceQuote struct {
+	Source string
+	Quote  Quote
+}
+
+// FetchQuotes queries every enabled, supporting provider for a single
+// symbol/vs pair concurrently, skipping providers that don't support the
+// pair or that error out.
+func (a *Aggregator) FetchQuotes(ctx context.Context, symbol, vs string) []SourceQuote {
+	var providers []Provider
+	for _, provider := range a.providers {
+		if a.enabled[provider.Name()] && provider.Supports(symbol, vs) {
+			providers = append(providers, provider)
+		}
+	}
+
+	quotes := make([]SourceQuote, len(providers))
+	ok := make([]bool, len(providers))
+	var wg sync.WaitGroup
+	for i, provider := range providers {
+		wg.Add(1)
+		go func(i int, provider Provider) {
+			defer wg.Done()
+			quote, err := provider.FetchPrice(ctx, symbol, vs)
+			if err != nil {
+				log.Printf("aggregator: %s %s/%s failed: %v", provider.Name(), symbol, vs, err)
+				return
+			}
+			quotes[i] = SourceQuote{Source: provider.Name(), Quote: quote}
+			ok[i] = true
+		}(i, provider)
+	}
+	wg.Wait()
+
+	successful := make([]SourceQuote, 0, len(quotes))
+	for i, got := range ok {
+		if got {
+			successful = append(successful, quotes[i])
+		}
+	}
+	return successful
+}
+
+// aggregator is the process-wide set of registered providers.
+var aggregator = NewAggregator(
+	&binanceProvider{client: priceClient},
+	&coinGeckoProvider{client: priceClient, resolver: symbolResolver},
+	&krakenProvider{client: priceClient},
+	&coinbaseProvider{client: priceClient},
+	&cryptoCompareProvider{client: priceClient},
+	&bitfinexProvider{client: priceClient},
+	&coinbaseAdvancedProvider{client: priceClient},
+)
+
+// init disables any providers named in DISABLED_PROVIDERS (comma-separated,
+// matching Provider.Name()), so a source can be turned off without a code
+// change, e.g. DISABLED_PROVIDERS=CoinGecko,Bitfinex.
+func init() {
+	for _, name := range strings.Split(os.Getenv("DISABLED_PROVIDERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			aggregator.SetEnabled(name, false)
+		}
+	}
+}
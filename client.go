@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxFetchAttempts bounds retries on 429/5xx so a persistently broken
+// source fails fast instead of blocking the caller's goroutine forever.
+const maxFetchAttempts = 3
+
+// ErrBreakerOpen is returned when a source's circuit breaker is tripped and
+// requests to it are being skipped for its cooldown window.
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+// APIError is a decoded error body from a provider, kept structured so
+// callers can log the provider's own code/message instead of a generic one.
+type APIError struct {
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.Code, e.Message)
+}
+
+// Breaker trips after a run of consecutive failures and stays open for a
+// cooldown window, so a dead source gets skipped instead of retried on
+// every request.
+type Breaker struct {
+	mu        sync.Mutex
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openUntil time.Time
+}
+
+// NewBreaker creates a breaker that opens after threshold consecutive
+// failures and stays open for cooldown.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a request may proceed, i.e. the breaker isn't open.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Now().After(b.openUntil)
+}
+
+// RecordSuccess resets the failure count and closes the breaker.
+func (b *Breaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+}
+
+// RecordFailure counts a failure, opening the breaker once threshold is hit.
+func (b *Breaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// PriceClient is a shared HTTP client that enforces a per-provider request
+// budget and trips a circuit breaker per provider on sustained failures, so
+// Aggregator.Fetch can't pile up goroutines against a dead source.
+type PriceClient struct {
+	http     *http.Client
+	limiters map[string]*rate.Limiter
+	breakers map[string]*Breaker
+}
+
+// NewPriceClient builds a client pre-configured with each provider's
+// published free-tier/public rate limit.
+func NewPriceClient() *PriceClient {
+	return &PriceClient{
+		http: &http.Client{Timeout: 10 * time.Second},
+		limiters: map[string]*rate.Limiter{
+			"binance":          rate.NewLimiter(rate.Every(50*time.Millisecond), 20), // ~1200 req/min
+			"coingecko":        rate.NewLimiter(rate.Every(3*time.Second), 3),        // ~20 req/min, free tier
+			"kraken":           rate.NewLimiter(rate.Every(time.Second), 5),
+			"coinbase":         rate.NewLimiter(rate.Every(200*time.Millisecond), 10),
+			"cryptocompare":    rate.NewLimiter(rate.Every(100*time.Millisecond), 10), // ~10 req/sec, free tier
+			"bitfinex":         rate.NewLimiter(rate.Every(700*time.Millisecond), 10), // ~90 req/min, public endpoints
+			"coinbaseadvanced": rate.NewLimiter(rate.Every(100*time.Millisecond), 10), // ~10 req/sec, public endpoints
+		},
+		breakers: map[string]*Breaker{
+			"binance":          NewBreaker(5, 30*time.Second),
+			"coingecko":        NewBreaker(5, time.Minute),
+			"kraken":           NewBreaker(5, 30*time.Second),
+			"coinbase":         NewBreaker(5, 30*time.Second),
+			"cryptocompare":    NewBreaker(5, 30*time.Second),
+			"bitfinex":         NewBreaker(5, 30*time.Second),
+			"coinbaseadvanced": NewBreaker(5, 30*time.Second),
+		},
+	}
+}
+
+// fetchPrice issues a rate-limited GET against url on behalf of source,
+// decoding the JSON body into target. 429/5xx responses are retried with
+// exponential backoff and jitter, honoring Retry-After when present; network
+// errors and those retryable 429/5xx responses count toward tripping
+// source's circuit breaker, so once it opens a subsequent call is skipped
+// outright while the breaker is open. A non-retryable 4xx (e.g. a pair this source doesn't offer) is
+// returned immediately without affecting the breaker.
+func (c *PriceClient) fetchPrice(source, url string, target interface{}) error {
+	breaker := c.breakers[source]
+	if breaker != nil && !breaker.Allow() {
+		return fmt.Errorf("%s: %w", source, ErrBreakerOpen)
+	}
+	limiter := c.limiters[source]
+
+	var lastErr error
+	for attempt := 0; attempt < maxFetchAttempts; attempt++ {
+		if limiter != nil {
+			if err := limiter.Wait(context.Background()); err != nil {
+				return err
+			}
+		}
+
+		resp, err := c.http.Get(url)
+		if err != nil {
+			lastErr = err
+			if breaker != nil {
+				breaker.RecordFailure()
+			}
+			time.Sleep(backoffDelay(attempt))
+			continue
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			err := json.NewDecoder(resp.Body).Decode(target)
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+			if breaker != nil {
+				breaker.RecordSuccess()
+			}
+			return nil
+		}
+
+		apiErr := decodeAPIError(resp)
+		retryAfter := resp.Header.Get("Retry-After")
+		resp.Body.Close()
+		lastErr = apiErr
+
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if !retryable {
+			// Ordinary 4xx (e.g. a pair this source doesn't offer) isn't a
+			// sign the source is unhealthy, so it shouldn't count toward
+			// tripping the breaker for every other symbol/currency on it.
+			return apiErr
+		}
+		if breaker != nil {
+			breaker.RecordFailure()
+		}
+		time.Sleep(retryDelay(retryAfter, attempt))
+	}
+	return lastErr
+}
+
+// backoffDelay returns an exponentially growing delay with jitter for the
+// given zero-based attempt number.
+func backoffDelay(attempt int) time.Duration {
+	base := time.Duration(1<<uint(attempt)) * 200 * time.Millisecond
+	return base + time.Duration(rand.Int63n(int64(base)+1))
+}
+
+// retryDelay honors a Retry-After header in seconds when present, otherwise
+// falls back to exponential backoff.
+func retryDelay(retryAfter string, attempt int) time.Duration {
+	if retryAfter != "" {
+		if secs, err := strconv.Atoi(retryAfter); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return backoffDelay(attempt)
+}
+
+func decodeAPIError(resp *http.Response) *APIError {
+	var body struct {
+		Code    int    `json:"code"`
+		Message string `json:"msg"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Message == "" {
+		return &APIError{Code: resp.StatusCode, Message: fmt.Sprintf("status %d", resp.StatusCode)}
+	}
+	return &APIError{Code: resp.StatusCode, Message: body.Message}
+}
@@ -1,171 +1,148 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-type APIResponse struct {
-	Source string `json:"source"`
-	Price  string `json:"price"`
-}
+// symbolResolver maps tickers to CoinGecko coin IDs, refreshing from
+// coins/list every 6h so newly listed coins work without a code change.
+var symbolResolver = NewSymbolResolver("coingecko_coins_cache.json", 6*time.Hour)
 
-var coinGeckoSymbols = map[string]string{
-	"BTC":  "bitcoin",
-	"ETH":  "ethereum",
-	"SOL":  "solana",
-	"DOGE": "dogecoin",
-	"SHIB": "shiba-inu",
-}
+// priceClient is shared across all registered providers so their rate
+// limits and circuit breakers apply process-wide, not per-request.
+var priceClient = NewPriceClient()
 
-var krakenSymbols = map[string]string{
-	"BTC":  "XXBTZUSD",
-	"ETH":  "XETHZUSD",
-	"SOL":  "SOLUSD",
-	"DOGE": "XDGUSD",
-	"SHIB": "SHIBUSD",
+type APIResponse struct {
+	Source string             `json:"source"`
+	Price  map[string]string  `json:"price"`
+	Volume map[string]float64 `json:"volume,omitempty"`
 }
 
-func fetchPrice(url string, target interface{}) error {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
-	}
+// PricePoint is a single (timestamp, price) sample of a market_chart series.
+type PricePoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Price     float64 `json:"price"`
+}
 
-	req.Header.Set("User-Agent", "gno-price-oracle/1.0")
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+// ErrCoinNotFound means the upstream source has no coin matching the symbol.
+var ErrCoinNotFound = errors.New("coin not found")
 
-	if resp.StatusCode != http.StatusOK {
-		var apiError struct {
-			Code    int    `json:"code"`
-			Message string `json:"msg"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&apiError); err != nil {
-			return fmt.Errorf("failed to decode error message: %v", err)
-		}
-		return fmt.Errorf("API error %d: %s", apiError.Code, apiError.Message)
-	}
+// ErrTooManyRequests means the upstream source rate-limited this request.
+var ErrTooManyRequests = errors.New("too many requests")
 
-	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
-		return err
-	}
+// unsupportedQuote marks a vs-currency that a given source has no pair for,
+// so callers can tell "not offered" apart from a transient fetch error.
+const unsupportedQuote = "unsupported"
 
-	return nil
+// krakenPairs maps a base symbol to its known Kraken pair per vs-currency.
+// Kraken's legacy asset codes (XXBT, ZUSD, ...) aren't algorithmically
+// derivable from ISO tickers, so pairs are listed explicitly as they're added.
+var krakenPairs = map[string]map[string]string{
+	"BTC":  {"USD": "XXBTZUSD", "EUR": "XXBTZEUR", "GBP": "XXBTZGBP"},
+	"ETH":  {"USD": "XETHZUSD", "EUR": "XETHZEUR", "GBP": "XETHZGBP", "BTC": "XETHXXBT"},
+	"SOL":  {"USD": "SOLUSD", "EUR": "SOLEUR"},
+	"DOGE": {"USD": "XDGUSD", "EUR": "XDGEUR"},
+	"SHIB": {"USD": "SHIBUSD"},
 }
 
-func getPriceFromBinance(symbol string) (string, error) {
-	url := fmt.Sprintf("https://api.binance.com/api/v3/ticker/price?symbol=%sUSDT", strings.ToUpper(symbol))
-	var result struct {
-		Price string `json:"price"`
-	}
-	err := fetchPrice(url, &result)
-	if err != nil {
-		return "", err
-	}
-	return result.Price, nil
-}
+// defaultVSCurrencies is used when the caller doesn't specify ?vs=.
+var defaultVSCurrencies = []string{"usd"}
 
-func getPriceFromCoinGecko(symbol string) (string, error) {
-	coinGeckoSymbol, ok := coinGeckoSymbols[strings.ToUpper(symbol)]
-	if !ok {
-		return "", fmt.Errorf("unknown symbol for CoinGecko: %s", symbol)
+// parseVSCurrencies turns a comma-separated "usd,eur,btc" query value into a
+// normalized, lower-cased list, defaulting to USD when empty.
+func parseVSCurrencies(vs string) []string {
+	if strings.TrimSpace(vs) == "" {
+		return defaultVSCurrencies
 	}
-	url := fmt.Sprintf("https://api.coingecko.com/api/v3/simple/price?ids=%s&vs_currencies=usd", coinGeckoSymbol)
-	var result map[string]map[string]float64
-	err := fetchPrice(url, &result)
-	if err != nil {
-		return "", err
+	parts := strings.Split(vs, ",")
+	currencies := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p != "" {
+			currencies = append(currencies, p)
+		}
+	}
+	if len(currencies) == 0 {
+		return defaultVSCurrencies
 	}
-	price := result[coinGeckoSymbol]["usd"]
-	return fmt.Sprintf("%.2f", price), nil
+	return currencies
 }
 
-func getPriceFromKraken(symbol string) (string, error) {
-	krakenPair, ok := krakenSymbols[strings.ToUpper(symbol)]
-	if !ok {
-		return "", fmt.Errorf("unknown symbol for Kraken: %s", symbol)
-	}
+// getHistoryFromCoinGecko fetches a market_chart series for coinID over the
+// given window, decoding CoinGecko's {"prices": [[ts_ms, price], ...]} shape.
+// The request goes through priceClient so it shares CoinGecko's rate limit
+// and circuit breaker with the price-fetching path instead of a bare
+// http.Get that could exhaust the free-tier quota out from under it.
+func getHistoryFromCoinGecko(coinID string, days int, interval, vs string) ([]PricePoint, error) {
+	url := fmt.Sprintf(
+		"https://api.coingecko.com/api/v3/coins/%s/market_chart?vs_currency=%s&days=%d&interval=%s",
+		coinID, strings.ToLower(vs), days, interval,
+	)
 
-	url := fmt.Sprintf("https://api.kraken.com/0/public/Ticker?pair=%s", krakenPair)
 	var result struct {
-		Result map[string]struct {
-			C []string `json:"c"`
-		} `json:"result"`
+		Prices [][2]float64 `json:"prices"`
 	}
-	err := fetchPrice(url, &result)
-	if err != nil {
-		return "", err
-	}
-	priceList := result.Result[krakenPair]
-	if len(priceList.C) == 0 {
-		return "", fmt.Errorf("price not found for %s", symbol)
+	if err := priceClient.fetchPrice("coingecko", url, &result); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.Code {
+			case http.StatusNotFound:
+				return nil, ErrCoinNotFound
+			case http.StatusTooManyRequests:
+				return nil, ErrTooManyRequests
+			}
+		}
+		return nil, err
 	}
-	return priceList.C[0], nil
-}
 
-func getPriceFromCoinbase(symbol string) (string, error) {
-	url := fmt.Sprintf("https://api.coinbase.com/v2/prices/%s-USD/spot", strings.ToUpper(symbol))
-	var result struct {
-		Data struct {
-			Amount string `json:"amount"`
-		} `json:"data"`
+	points := make([]PricePoint, len(result.Prices))
+	for i, p := range result.Prices {
+		points[i] = PricePoint{Timestamp: int64(p[0]), Price: p[1]}
 	}
-	err := fetchPrice(url, &result)
-	if err != nil {
-		return "", err
-	}
-	return result.Data.Amount, nil
+	return points, nil
 }
 
-func fetchPricesConcurrently(symbol string) []APIResponse {
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	sources := []struct {
-		Name   string
-		Fetch  func(string) (string, error)
-		Symbol string
-	}{
-		{"Binance", getPriceFromBinance, symbol},
-		{"CoinGecko", getPriceFromCoinGecko, symbol},
-		{"Kraken", getPriceFromKraken, symbol},
-		{"Coinbase", getPriceFromCoinbase, symbol},
-	}
-
-	prices := make([]APIResponse, len(sources))
-	for i, source := range sources {
-		wg.Add(1)
-		go func(i int, source struct {
-			Name   string
-			Fetch  func(string) (string, error)
-			Symbol string
-		}) {
-			defer wg.Done()
-			price, err := source.Fetch(source.Symbol)
-			if err != nil {
-				price = err.Error()
+// mergeSeries buckets multiple price series by their (already shared)
+// timestamp so the response reports one row per sample instant.
+func mergeSeries(series map[string][]PricePoint) []map[string]interface{} {
+	buckets := make(map[int64]map[string]interface{})
+	var order []int64
+	for source, points := range series {
+		for _, p := range points {
+			row, ok := buckets[p.Timestamp]
+			if !ok {
+				row = map[string]interface{}{"timestamp": p.Timestamp}
+				buckets[p.Timestamp] = row
+				order = append(order, p.Timestamp)
 			}
-			mu.Lock()
-			prices[i] = APIResponse{Source: fmt.Sprintf("%s (%s)", source.Name, strings.ToUpper(symbol)), Price: price}
-			mu.Unlock()
-		}(i, source)
+			row[source] = p.Price
+		}
 	}
 
-	wg.Wait()
-	return prices
+	rows := make([]map[string]interface{}, 0, len(order))
+	for _, ts := range order {
+		rows = append(rows, buckets[ts])
+	}
+	return rows
 }
 
 // main function to start the server
 func main() {
+	if err := symbolResolver.Refresh(); err != nil {
+		log.Printf("symbol resolver: initial refresh failed, serving from cache: %v", err)
+	}
+	symbolResolver.StartAutoRefresh(6 * time.Hour)
+
 	r := gin.Default()
 
 	r.GET("/api/price/:symbol", func(c *gin.Context) {
@@ -174,8 +151,115 @@ func main() {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing symbol"})
 			return
 		}
-		prices := fetchPricesConcurrently(symbol)
-		c.JSON(http.StatusOK, gin.H{"prices": prices})
+		vsCurrencies := parseVSCurrencies(c.Query("vs"))
+		prices := aggregator.Fetch(context.Background(), []string{symbol}, vsCurrencies)
+
+		aggregated := make(map[string]AggregatedPrice, len(vsCurrencies))
+		for _, vs := range vsCurrencies {
+			agg, _ := computeAggregate(vs, parsedQuotes(prices, vs))
+			aggregated[vs] = agg
+		}
+
+		c.JSON(http.StatusOK, gin.H{"prices": prices, "aggregated": aggregated})
+	})
+
+	r.GET("/api/aggregate/:symbol", func(c *gin.Context) {
+		symbol := c.Param("symbol")
+		if symbol == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing symbol"})
+			return
+		}
+		vs := strings.ToLower(c.DefaultQuery("vs", "usd"))
+
+		quotes := aggregator.FetchQuotes(context.Background(), symbol, vs)
+		agg, deviations := computeAggregate(vs, quotes)
+		c.JSON(http.StatusOK, gin.H{"symbol": strings.ToUpper(symbol), "aggregate": agg, "deviations": deviations})
+	})
+
+	r.GET("/ws/price/:symbol", func(c *gin.Context) {
+		symbol := c.Param("symbol")
+		if symbol == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing symbol"})
+			return
+		}
+		vs := strings.ToLower(c.DefaultQuery("vs", "usd"))
+
+		conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			log.Printf("stream: websocket upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		updates, unsubscribe := streamHub.Subscribe(symbol, vs)
+		defer unsubscribe()
+
+		for update := range updates {
+			if err := conn.WriteJSON(update); err != nil {
+				return
+			}
+		}
+	})
+
+	r.GET("/sse/price/:symbol", func(c *gin.Context) {
+		symbol := c.Param("symbol")
+		if symbol == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing symbol"})
+			return
+		}
+		vs := strings.ToLower(c.DefaultQuery("vs", "usd"))
+
+		updates, unsubscribe := streamHub.Subscribe(symbol, vs)
+		defer unsubscribe()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case update, ok := <-updates:
+				if !ok {
+					return false
+				}
+				c.SSEvent("price", update)
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	})
+
+	r.GET("/api/history/:symbol", func(c *gin.Context) {
+		symbol := c.Param("symbol")
+		coinID, ok := symbolResolver.Lookup(symbol)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown symbol: %s", symbol)})
+			return
+		}
+
+		days, err := strconv.Atoi(c.Query("days"))
+		if err != nil || days <= 0 {
+			days = 30
+		}
+		interval := c.DefaultQuery("interval", "daily")
+		vs := c.DefaultQuery("vs", "usd")
+
+		points, err := getHistoryFromCoinGecko(coinID, days, interval, vs)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrCoinNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			case errors.Is(err, ErrTooManyRequests):
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			}
+			return
+		}
+
+		series := mergeSeries(map[string][]PricePoint{"CoinGecko": points})
+		c.JSON(http.StatusOK, gin.H{"symbol": strings.ToUpper(symbol), "vs": strings.ToLower(vs), "prices": series})
 	})
 
 	r.Run(":8080") // Run the server on port 8080
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// streamPollInterval is how often a symbol's poller refreshes its aggregate
+// from REST quotes. It polls on this interval whether or not a native
+// exchange feed has a fresh price cached.
+const streamPollInterval = 5 * time.Second
+
+// streamEpsilon is the minimum fractional price move (0.0005 = 5bps)
+// required before a subscriber is notified, so a stream doesn't flood
+// slow clients with noise-level updates.
+const streamEpsilon = 0.0005
+
+// PriceUpdate is one push sent to every subscriber of a symbol's stream.
+type PriceUpdate struct {
+	Symbol    string  `json:"symbol"`
+	VS        string  `json:"vs"`
+	Median    float64 `json:"median"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// wsFeed caches the latest trade price from an exchange's native websocket
+// stream, so a poller can read a sub-second price without an extra REST
+// round trip.
+type wsFeed struct {
+	mu      sync.RWMutex
+	price   float64
+	updated time.Time
+}
+
+func (f *wsFeed) set(price float64) {
+	f.mu.Lock()
+	f.price = price
+	f.updated = time.Now()
+	f.mu.Unlock()
+}
+
+// latest returns the last price pushed within maxAge, or ok=false if the
+// feed has no price yet or it's gone stale (e.g. the connection dropped).
+func (f *wsFeed) latest(maxAge time.Duration) (float64, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.price == 0 || time.Since(f.updated) > maxAge {
+		return 0, false
+	}
+	return f.price, true
+}
+
+// maxWSReconnectDelay caps how long runWSFeed will wait between redial
+// attempts. Without a cap, backoffDelay's exponential growth reaches
+// multi-day sleeps within a couple dozen attempts, which would leave a feed
+// goroutine unable to recover from a sustained outage until the process
+// restarts.
+const maxWSReconnectDelay = 30 * time.Second
+
+// maxWSReconnectBackoffAttempt is the highest attempt number ever handed to
+// backoffDelay: from this attempt on its delay (at least 51.2s) is always
+// past maxWSReconnectDelay, so holding attempt here once reached keeps it
+// from growing without bound for the life of a long outage.
+const maxWSReconnectBackoffAttempt = 8
+
+// runWSFeed dials url, optionally sending a subscribe payload on connect,
+// and invokes onMessage for every frame received until ctx is canceled.
+// A dropped connection is redialed at once, and failed dials are retried
+// with capped backoff rather than given up on, since a feed is expected to
+// run for as long as its symbol has subscribers.
+func runWSFeed(ctx context.Context, url string, subscribe []byte, onMessage func([]byte)) {
+	attempt := 0
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			log.Printf("stream: dial %s failed: %v", url, err)
+			delay := backoffDelay(attempt)
+			if delay > maxWSReconnectDelay {
+				delay = maxWSReconnectDelay
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			if attempt < maxWSReconnectBackoffAttempt {
+				attempt++
+			}
+			continue
+		}
+		attempt = 0 // reset backoff after a successful connect
+
+		if subscribe != nil {
+			if err := conn.WriteMessage(websocket.TextMessage, subscribe); err != nil {
+				conn.Close()
+				continue
+			}
+		}
+
+		go func() {
+			<-ctx.Done()
+			conn.Close()
+		}()
+
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				break
+			}
+			onMessage(msg)
+		}
+		conn.Close()
+	}
+}
+
+// startBinanceFeed keeps feed updated from Binance's raw trade stream for
+// symbol/vs until ctx is canceled.
+func startBinanceFeed(ctx context.Context, feed *wsFeed, symbol, vs string) {
+	stream := strings.ToLower(symbol) + strings.ToLower(binanceVSCurrency(vs)) + "@trade"
+	url := fmt.Sprintf("wss://stream.binance.com:9443/ws/%s", stream)
+	runWSFeed(ctx, url, nil, func(msg []byte) {
+		var trade struct {
+			Price string `json:"p"`
+		}
+		if err := json.Unmarshal(msg, &trade); err != nil {
+			return
+		}
+		if price, err := strconv.ParseFloat(trade.Price, 64); err == nil {
+			feed.set(price)
+		}
+	})
+}
+
+// startCoinbaseFeed keeps feed updated from Coinbase's public "matches"
+// channel for symbol/vs until ctx is canceled.
+func startCoinbaseFeed(ctx context.Context, feed *wsFeed, symbol, vs string) {
+	subscribe, _ := json.Marshal(map[string]interface{}{
+		"type":        "subscribe",
+		"product_ids": []string{fmt.Sprintf("%s-%s", strings.ToUpper(symbol), strings.ToUpper(vs))},
+		"channels":    []string{"matches"},
+	})
+	runWSFeed(ctx, "wss://ws-feed.exchange.coinbase.com", subscribe, func(msg []byte) {
+		var match struct {
+			Type  string `json:"type"`
+			Price string `json:"price"`
+		}
+		if err := json.Unmarshal(msg, &match); err != nil || match.Type != "match" {
+			return
+		}
+		if price, err := strconv.ParseFloat(match.Price, 64); err == nil {
+			feed.set(price)
+		}
+	})
+}
+
+// symbolStream runs one background poller for one (symbol, vs) pair,
+// fanning its updates out to however many subscribers are attached. It is
+// shared across every viewer of the same pair, regardless of transport, so
+// N subscribers cost one poller instead of N.
+type symbolStream struct {
+	symbol string
+	vs     string
+	cancel context.CancelFunc
+
+	binanceFeed  *wsFeed
+	coinbaseFeed *wsFeed
+
+	mu          sync.Mutex
+	subscribers map[chan PriceUpdate]struct{}
+	lastMedian  float64
+}
+
+func newSymbolStream(symbol, vs string) *symbolStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &symbolStream{
+		symbol:       symbol,
+		vs:           vs,
+		cancel:       cancel,
+		binanceFeed:  &wsFeed{},
+		coinbaseFeed: &wsFeed{},
+		subscribers:  make(map[chan PriceUpdate]struct{}),
+	}
+	go startBinanceFeed(ctx, s.binanceFeed, symbol, vs)
+	go startCoinbaseFeed(ctx, s.coinbaseFeed, symbol, vs)
+	go s.run(ctx)
+	return s
+}
+
+func (s *symbolStream) run(ctx context.Context) {
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.poll()
+		}
+	}
+}
+
+func (s *symbolStream) poll() {
+	quotes := aggregator.FetchQuotes(context.Background(), s.symbol, s.vs)
+	s.preferFastFeeds(quotes)
+	agg, _ := computeAggregate(s.vs, quotes)
+	if agg.Sources == 0 {
+		return
+	}
+	s.publish(agg.Median)
+}
+
+// preferFastFeeds overwrites a Binance/Coinbase quote's price with the last
+// trade from its native websocket feed, when that trade is no older than two
+// poll intervals. It runs only inside poll, so feed trades reach the
+// aggregate at each poll cycle; a trade between poll cycles is not pushed
+// to subscribers until the next poll.
+func (s *symbolStream) preferFastFeeds(quotes []SourceQuote) {
+	for i, q := range quotes {
+		var feed *wsFeed
+		switch q.Source {
+		case "Binance":
+			feed = s.binanceFeed
+		case "Coinbase":
+			feed = s.coinbaseFeed
+		default:
+			continue
+		}
+		if price, ok := feed.latest(2 * streamPollInterval); ok {
+			quotes[i].Quote.Price = strconv.FormatFloat(price, 'f', -1, 64)
+		}
+	}
+}
+
+// publish notifies every subscriber if median has moved by more than
+// streamEpsilon since the last push. A subscriber with a full buffer is
+// skipped rather than blocking the poller on a slow client.
+func (s *symbolStream) publish(median float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastMedian != 0 {
+		move := (median - s.lastMedian) / s.lastMedian
+		if move < 0 {
+			move = -move
+		}
+		if move < streamEpsilon {
+			return
+		}
+	}
+	s.lastMedian = median
+
+	update := PriceUpdate{
+		Symbol:    strings.ToUpper(s.symbol),
+		VS:        s.vs,
+		Median:    median,
+		Timestamp: time.Now().Unix(),
+	}
+	for ch := range s.subscribers {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
+}
+
+func (s *symbolStream) subscribe() chan PriceUpdate {
+	ch := make(chan PriceUpdate, 4)
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch
+}
+
+// unsubscribe removes ch from the stream's subscribers and reports whether
+// that was the last one, so the caller can tear the stream down.
+func (s *symbolStream) unsubscribe(ch chan PriceUpdate) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, ch)
+	close(ch)
+	return len(s.subscribers) == 0
+}
+
+// StreamHub coalesces subscribers onto one symbolStream per (symbol, vs)
+// pair, starting a poller on the first subscriber and tearing it down
+// after the last one leaves.
+type StreamHub struct {
+	mu      sync.Mutex
+	streams map[string]*symbolStream
+}
+
+func NewStreamHub() *StreamHub {
+	return &StreamHub{streams: make(map[string]*symbolStream)}
+}
+
+func streamKey(symbol, vs string) string {
+	return strings.ToUpper(symbol) + ":" + strings.ToLower(vs)
+}
+
+// Subscribe returns a channel of updates for (symbol, vs) and a function
+// the caller must call when done to release the subscription.
+//
+// Both the find-or-create-and-attach sequence here and the "is this the
+// last subscriber" teardown in the returned closure run under h.mu, so a
+// concurrent Subscribe for the same pair can't attach a channel to a
+// stream that's mid-teardown: either it observes the stream before the
+// other side deletes it (and isn't the last subscriber, so no teardown
+// happens), or it observes the key already gone and starts a fresh stream.
+func (h *StreamHub) Subscribe(symbol, vs string) (chan PriceUpdate, func()) {
+	key := streamKey(symbol, vs)
+
+	h.mu.Lock()
+	stream, ok := h.streams[key]
+	if !ok {
+		stream = newSymbolStream(symbol, vs)
+		h.streams[key] = stream
+	}
+	ch := stream.subscribe()
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if stream.unsubscribe(ch) && h.streams[key] == stream {
+			delete(h.streams, key)
+			stream.cancel()
+		}
+	}
+}
+
+// streamHub is the process-wide set of active symbol pollers.
+var streamHub = NewStreamHub()
+
+// wsUpgrader upgrades /ws/price/:symbol connections. CheckOrigin allows any
+// origin since this is a public, read-only price feed with no session state.
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}